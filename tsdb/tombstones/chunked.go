@@ -0,0 +1,503 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tombstones
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/encoding"
+	tsdb_errors "github.com/prometheus/prometheus/tsdb/errors"
+	"github.com/prometheus/prometheus/tsdb/fileutil"
+)
+
+const (
+	// chunkedContainerMarker follows the magic number in a chunked
+	// tombstones file. It is chosen outside the range of any Codec format
+	// byte (currently 1 and 2) so ReadTombstones can tell a chunked file
+	// from the legacy single-CRC layout just by looking at that byte.
+	chunkedContainerMarker = 0xFF
+
+	// chunkedHeaderSize is the magic number, the chunkedContainerMarker and
+	// the Codec format byte used to encode every data chunk's payload.
+	chunkedHeaderSize = 4 + 1 + 1
+
+	// chunkPayloadSize is the target size, in bytes, of a data chunk's
+	// encoded series before framing overhead. A series larger than this on
+	// its own still gets a whole chunk to itself, rather than being split
+	// across chunks, so that losing one chunk never corrupts a series that
+	// survived elsewhere.
+	chunkPayloadSize = 32 * 1024
+
+	chunkTypeData  byte = 0
+	chunkTypeIndex byte = 1
+
+	chunkLengthSize = 4
+	chunkCRCSize    = 4
+)
+
+// writeChunk frames typ and payload as [len(type+payload)][type][payload][crc32c]
+// and appends it to buf.
+func writeChunk(buf *[]byte, typ byte, payload []byte) {
+	block := make([]byte, 0, 1+len(payload))
+	block = append(block, typ)
+	block = append(block, payload...)
+
+	var lenBytes [chunkLengthSize]byte
+	binary.BigEndian.PutUint32(lenBytes[:], uint32(len(block)))
+	*buf = append(*buf, lenBytes[:]...)
+	*buf = append(*buf, block...)
+
+	var crcBytes [chunkCRCSize]byte
+	binary.BigEndian.PutUint32(crcBytes[:], crc32Checksum(block))
+	*buf = append(*buf, crcBytes[:]...)
+}
+
+func crc32Checksum(b []byte) uint32 {
+	h := newCRC32()
+	h.Write(b) //nolint:errcheck // hash.Hash.Write never returns an error.
+	return h.Sum32()
+}
+
+// readChunk parses a single framed chunk from the start of b. ok is false
+// if the chunk's CRC doesn't match its payload; consumed is always the
+// number of bytes the chunk occupies on disk, even when ok is false, so a
+// caller can skip over a corrupt chunk and keep scanning.
+func readChunk(b []byte) (typ byte, payload []byte, consumed int, ok bool, err error) {
+	if len(b) < chunkLengthSize+chunkCRCSize+1 {
+		return 0, nil, 0, false, fmt.Errorf("truncated tombstones chunk header: %w", encoding.ErrInvalidSize)
+	}
+	l := int(binary.BigEndian.Uint32(b[:chunkLengthSize]))
+	consumed = chunkLengthSize + l + chunkCRCSize
+	if l < 1 || consumed > len(b) {
+		return 0, nil, 0, false, fmt.Errorf("truncated tombstones chunk body: %w", encoding.ErrInvalidSize)
+	}
+	block := b[chunkLengthSize : chunkLengthSize+l]
+	crc := binary.BigEndian.Uint32(b[chunkLengthSize+l : consumed])
+	ok = crc32Checksum(block) == crc
+	return block[0], block[1:], consumed, ok, nil
+}
+
+// chunkIndexEntry describes one data chunk's position and the (inclusive)
+// range of series refs it holds.
+type chunkIndexEntry struct {
+	firstRef, lastRef storage.SeriesRef
+	offset, length    int
+	count             int
+}
+
+// seriesRecord is one series pulled out of a Reader via Iter, held in memory
+// just long enough to be grouped into chunks and handed to a Codec.
+type seriesRecord struct {
+	ref storage.SeriesRef
+	ivs Intervals
+}
+
+// recordsReader adapts a slice of seriesRecord back into a Reader, so a
+// subset of series can be run through a Codec's EncodeBody/DecodeBody the
+// same way a full MemTombstones would be.
+type recordsReader struct {
+	records []seriesRecord
+}
+
+func (r *recordsReader) Get(ref storage.SeriesRef) (Intervals, error) {
+	for _, rec := range r.records {
+		if rec.ref == ref {
+			return rec.ivs, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *recordsReader) Iter(f func(storage.SeriesRef, Intervals) error) error {
+	for _, rec := range r.records {
+		if err := f(rec.ref, rec.ivs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *recordsReader) Total() uint64 {
+	var total uint64
+	for _, rec := range r.records {
+		total += uint64(len(rec.ivs))
+	}
+	return total
+}
+
+func (*recordsReader) Close() error { return nil }
+
+// seriesEstimatedSize is a rough estimate of how many bytes ivs will take
+// once encoded, used only to decide when a chunk is full. The actual
+// on-disk size is whatever codec.EncodeBody produces for the chunk's whole
+// batch of series, which may be smaller (e.g. a compressing Codec) or
+// larger (e.g. the v1 codec repeats the ref for every interval) than this
+// estimate.
+func seriesEstimatedSize(ivs Intervals) int {
+	return 2*binary.MaxVarintLen64 + len(ivs)*2*binary.MaxVarintLen64
+}
+
+// WriteChunkedFile writes tr to dir in the chunked container format: a
+// sequence of data chunks, each holding a batch of series encoded with
+// codec and individually CRC32C-checksummed, followed by an index chunk
+// mapping series-ref ranges to chunk offsets. Unlike the legacy single-CRC
+// layout written by WriteFileWithCodec, a corrupted chunk here only costs
+// the series inside it: see Repair. The codec used is recorded in the file
+// header so ReadTombstones can decode each chunk with it.
+func WriteChunkedFile(logger *slog.Logger, dir string, tr Reader, codec Codec) (int64, error) {
+	path := filepath.Join(dir, TombstonesFilename)
+	tmp := path + ".tmp"
+
+	var records []seriesRecord
+	if err := tr.Iter(func(ref storage.SeriesRef, ivs Intervals) error {
+		records = append(records, seriesRecord{ref: ref, ivs: ivs})
+		return nil
+	}); err != nil {
+		return 0, fmt.Errorf("iterating tombstones: %w", err)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].ref < records[j].ref })
+
+	out := make([]byte, 0, 4096)
+	var header [chunkedHeaderSize]byte
+	binary.BigEndian.PutUint32(header[:4], MagicTombstone)
+	header[4] = chunkedContainerMarker
+	header[5] = codec.Format()
+	out = append(out, header[:]...)
+
+	var entries []chunkIndexEntry
+	var pending []seriesRecord
+	var pendingSize int
+
+	flush := func() error {
+		if len(pending) == 0 {
+			return nil
+		}
+		body, err := codec.EncodeBody(&recordsReader{records: pending})
+		if err != nil {
+			return fmt.Errorf("encoding tombstones chunk: %w", err)
+		}
+		count := 0
+		for _, r := range pending {
+			count += len(r.ivs)
+		}
+		offset := len(out)
+		writeChunk(&out, chunkTypeData, body)
+		entries = append(entries, chunkIndexEntry{
+			firstRef: pending[0].ref,
+			lastRef:  pending[len(pending)-1].ref,
+			offset:   offset,
+			length:   len(out) - offset,
+			count:    count,
+		})
+		pending = nil
+		pendingSize = 0
+		return nil
+	}
+
+	for _, r := range records {
+		sz := seriesEstimatedSize(r.ivs)
+		if len(pending) > 0 && pendingSize+sz > chunkPayloadSize {
+			if err := flush(); err != nil {
+				return 0, err
+			}
+		}
+		pending = append(pending, r)
+		pendingSize += sz
+	}
+	if err := flush(); err != nil {
+		return 0, err
+	}
+
+	indexBuf := encoding.Encbuf{}
+	indexBuf.PutUvarint64(uint64(len(entries)))
+	for _, e := range entries {
+		indexBuf.PutUvarint64(uint64(e.firstRef))
+		indexBuf.PutUvarint64(uint64(e.lastRef))
+		indexBuf.PutUvarint64(uint64(e.offset))
+		indexBuf.PutUvarint64(uint64(e.length))
+		indexBuf.PutUvarint64(uint64(e.count))
+	}
+	indexOffset := len(out)
+	writeChunk(&out, chunkTypeIndex, indexBuf.Get())
+
+	var trailer [8]byte
+	binary.BigEndian.PutUint64(trailer[:], uint64(indexOffset))
+	out = append(out, trailer[:]...)
+
+	f, err := os.Create(tmp)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if f != nil {
+			if err := f.Close(); err != nil {
+				logger.Error("close tmp file", "err", err.Error())
+			}
+		}
+		if err := os.RemoveAll(tmp); err != nil {
+			logger.Error("remove tmp file", "err", err.Error())
+		}
+	}()
+
+	n, err := f.Write(out)
+	if err != nil {
+		return 0, fmt.Errorf("writing chunked tombstones: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return 0, tsdb_errors.NewMulti(err, f.Close()).Err()
+	}
+	if err := f.Close(); err != nil {
+		return 0, err
+	}
+	f = nil
+	return int64(n), fileutil.Replace(tmp, path)
+}
+
+// chunkedReader serves Get/Iter/Total off a chunked tombstones file held
+// entirely in memory, using the index to avoid decoding chunks that don't
+// contain the requested series.
+type chunkedReader struct {
+	raw     []byte
+	entries []chunkIndexEntry
+	codec   Codec
+}
+
+func readChunkedTombstones(b []byte) (Reader, error) {
+	if len(b) < chunkedHeaderSize+8 {
+		return nil, fmt.Errorf("chunked tombstones file too small: %w", encoding.ErrInvalidSize)
+	}
+	codec, ok := codecs[b[5]]
+	if !ok {
+		return nil, fmt.Errorf("invalid chunked tombstones codec %x", b[5])
+	}
+
+	indexOffset := binary.BigEndian.Uint64(b[len(b)-8:])
+	if indexOffset >= uint64(len(b)-8) {
+		return nil, fmt.Errorf("chunked tombstones index offset out of range; run Repair")
+	}
+
+	typ, payload, _, ok, err := readChunk(b[indexOffset : len(b)-8])
+	if err != nil {
+		return nil, fmt.Errorf("reading tombstones index chunk: %w", err)
+	}
+	if !ok || typ != chunkTypeIndex {
+		return nil, fmt.Errorf("corrupt tombstones index chunk; run Repair")
+	}
+
+	entries, err := decodeIndex(payload)
+	if err != nil {
+		return nil, err
+	}
+	return &chunkedReader{raw: b, entries: entries, codec: codec}, nil
+}
+
+func decodeIndex(payload []byte) ([]chunkIndexEntry, error) {
+	d := &encoding.Decbuf{B: payload}
+	n := d.Uvarint64()
+	entries := make([]chunkIndexEntry, 0, n)
+	for i := uint64(0); i < n; i++ {
+		e := chunkIndexEntry{
+			firstRef: storage.SeriesRef(d.Uvarint64()),
+			lastRef:  storage.SeriesRef(d.Uvarint64()),
+			offset:   int(d.Uvarint64()),
+			length:   int(d.Uvarint64()),
+			count:    int(d.Uvarint64()),
+		}
+		entries = append(entries, e)
+	}
+	if d.Err() != nil {
+		return nil, d.Err()
+	}
+	return entries, nil
+}
+
+func (r *chunkedReader) Get(ref storage.SeriesRef) (Intervals, error) {
+	idx := sort.Search(len(r.entries), func(i int) bool { return r.entries[i].lastRef >= ref })
+	if idx == len(r.entries) || ref < r.entries[idx].firstRef || ref > r.entries[idx].lastRef {
+		return nil, nil
+	}
+	e := r.entries[idx]
+	typ, payload, _, ok, err := readChunk(r.raw[e.offset:])
+	if err != nil {
+		return nil, err
+	}
+	if !ok || typ != chunkTypeData {
+		return nil, fmt.Errorf("tombstones chunk for series %d is corrupt; run Repair", ref)
+	}
+
+	sub, err := r.codec.DecodeBody(payload)
+	if err != nil {
+		return nil, fmt.Errorf("decoding tombstones chunk for series %d: %w", ref, err)
+	}
+	return sub.Get(ref)
+}
+
+// Iter calls f for every series in every chunk that passes its CRC check.
+// Chunks that fail their CRC are silently skipped: that's the isolation
+// this format buys over the legacy single-CRC layout. Use Repair to find
+// out what, if anything, was dropped.
+func (r *chunkedReader) Iter(f func(storage.SeriesRef, Intervals) error) error {
+	for _, e := range r.entries {
+		typ, payload, _, ok, err := readChunk(r.raw[e.offset:])
+		if err != nil || !ok || typ != chunkTypeData {
+			continue
+		}
+		sub, err := r.codec.DecodeBody(payload)
+		if err != nil {
+			continue
+		}
+		if err := sub.Iter(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *chunkedReader) Total() uint64 {
+	var total uint64
+	for _, e := range r.entries {
+		total += uint64(e.count)
+	}
+	return total
+}
+
+func (*chunkedReader) Close() error { return nil }
+
+// RepairReport summarizes what Repair found.
+type RepairReport struct {
+	// DroppedRefs lists the (inclusive) series-ref ranges that were
+	// dropped because their chunk failed its CRC32C check. The range is
+	// left zero-valued when the chunk's codec doesn't support best-effort
+	// range recovery from corrupt bytes (see bestEffortRefRange).
+	DroppedRefs []RepairedRange
+	// DroppedIntervals is the number of tombstone intervals lost across
+	// all dropped ranges, as counted by bestEffortRefRange; chunks whose
+	// codec it can't scan contribute zero.
+	DroppedIntervals int
+}
+
+// RepairedRange is a series-ref range dropped by Repair.
+type RepairedRange struct {
+	FirstRef, LastRef storage.SeriesRef
+}
+
+// Repair reads the chunked tombstones file in dir and returns the tombstones
+// it could still recover, plus a report of any chunk it had to drop because
+// its CRC32C didn't match. It re-derives chunk boundaries by scanning the
+// file sequentially rather than trusting the (possibly corrupt) index, so it
+// works even when the index chunk itself is damaged.
+func Repair(dir string) (Reader, RepairReport, error) {
+	b, err := os.ReadFile(filepath.Join(dir, TombstonesFilename))
+	if err != nil {
+		return nil, RepairReport{}, err
+	}
+	if len(b) < chunkedHeaderSize || binary.BigEndian.Uint32(b[:4]) != MagicTombstone || b[4] != chunkedContainerMarker {
+		return nil, RepairReport{}, fmt.Errorf("not a chunked tombstones file; nothing to repair")
+	}
+	codec, ok := codecs[b[5]]
+	if !ok {
+		return nil, RepairReport{}, fmt.Errorf("invalid chunked tombstones codec %x", b[5])
+	}
+
+	out := NewMemTombstones()
+	var report RepairReport
+
+	pos := chunkedHeaderSize
+	for pos < len(b) {
+		typ, payload, consumed, ok, err := readChunk(b[pos:])
+		if err != nil {
+			// Can't even parse a chunk header: nothing more to recover past
+			// this point.
+			break
+		}
+		if typ == chunkTypeIndex {
+			// The index marks the end of the data region; Repair doesn't
+			// need it; it already recomputed everything from the chunks.
+			break
+		}
+		if !ok {
+			firstRef, lastRef, n := bestEffortRefRange(codec, payload)
+			report.DroppedRefs = append(report.DroppedRefs, RepairedRange{FirstRef: firstRef, LastRef: lastRef})
+			report.DroppedIntervals += n
+			pos += consumed
+			continue
+		}
+
+		sub, err := codec.DecodeBody(payload)
+		if err != nil {
+			// The CRC matched but the payload still didn't parse; treat it
+			// the same as a failed CRC rather than risk recovering
+			// partially-wrong intervals.
+			firstRef, lastRef, n := bestEffortRefRange(codec, payload)
+			report.DroppedRefs = append(report.DroppedRefs, RepairedRange{FirstRef: firstRef, LastRef: lastRef})
+			report.DroppedIntervals += n
+			pos += consumed
+			continue
+		}
+		// Some codecs (e.g. v2) only decode a series' intervals during Iter,
+		// so collect the whole chunk first and drop it if any series fails.
+		var recovered []seriesRecord
+		if err := sub.Iter(func(ref storage.SeriesRef, ivs Intervals) error {
+			recovered = append(recovered, seriesRecord{ref: ref, ivs: ivs})
+			return nil
+		}); err != nil {
+			firstRef, lastRef, n := bestEffortRefRange(codec, payload)
+			report.DroppedRefs = append(report.DroppedRefs, RepairedRange{FirstRef: firstRef, LastRef: lastRef})
+			report.DroppedIntervals += n
+			pos += consumed
+			continue
+		}
+		for _, r := range recovered {
+			out.AddInterval(r.ref, r.ivs...)
+		}
+		pos += consumed
+	}
+
+	return out, report, nil
+}
+
+// bestEffortRefRange tries to recover the series-ref range covered by a
+// chunk that failed to decode, for reporting purposes. It only knows how to
+// scan the v1 codec's flat (ref, mint, maxt) layout, since that's the only
+// one simple enough to walk without trusting structure (a length-prefixed
+// footer, or compression, can't be scanned speculatively); for any other
+// codec it reports an empty range rather than guess.
+func bestEffortRefRange(codec Codec, payload []byte) (first, last storage.SeriesRef, intervals int) {
+	if codec.Format() != tombstoneFormatV1 {
+		return 0, 0, 0
+	}
+	d := &encoding.Decbuf{B: payload}
+	seen := false
+	for d.Len() > 0 {
+		ref := storage.SeriesRef(d.Uvarint64())
+		d.Varint64()
+		d.Varint64()
+		if d.Err() != nil {
+			break
+		}
+		if !seen {
+			first = ref
+			seen = true
+		}
+		last = ref
+		intervals++
+	}
+	return first, last, intervals
+}
@@ -0,0 +1,288 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tombstones
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/encoding"
+)
+
+const (
+	tombstoneCompressionNone = 0
+	tombstoneCompressionZstd = 1
+)
+
+// Codec encodes and decodes the body of a tombstones payload, i.e.
+// everything after the leading format byte. Registering a Codec with
+// RegisterCodec lets WriteFileWithCodec and Decode negotiate the on-disk
+// layout via that format byte instead of hard-coding tombstoneFormatV1.
+type Codec interface {
+	// Format identifies this codec in the on-disk format byte.
+	Format() byte
+	// EncodeBody encodes tr's intervals, excluding the format byte.
+	EncodeBody(tr Reader) ([]byte, error)
+	// DecodeBody decodes a payload produced by EncodeBody.
+	DecodeBody(b []byte) (Reader, error)
+}
+
+var codecs = map[byte]Codec{}
+
+// RegisterCodec makes c available to Decode via its Format byte. It panics
+// if a codec is already registered for that byte, since that would silently
+// make old files unreadable.
+func RegisterCodec(c Codec) {
+	if _, ok := codecs[c.Format()]; ok {
+		panic(fmt.Sprintf("tombstones: codec already registered for format %x", c.Format()))
+	}
+	codecs[c.Format()] = c
+}
+
+func init() {
+	RegisterCodec(v1Codec{})
+	RegisterCodec(&v2Codec{})
+}
+
+// v1Codec is the original flat (ref, mint, maxt) varint encoding.
+type v1Codec struct{}
+
+func (v1Codec) Format() byte { return tombstoneFormatV1 }
+
+func (v1Codec) EncodeBody(tr Reader) ([]byte, error) {
+	buf := encoding.Encbuf{}
+	err := tr.Iter(func(ref storage.SeriesRef, ivs Intervals) error {
+		for _, iv := range ivs {
+			buf.PutUvarint64(uint64(ref))
+			buf.PutVarint64(iv.Mint)
+			buf.PutVarint64(iv.Maxt)
+		}
+		return nil
+	})
+	return buf.Get(), err
+}
+
+func (v1Codec) DecodeBody(b []byte) (Reader, error) {
+	d := &encoding.Decbuf{B: b}
+	stonesMap := NewMemTombstones()
+	for d.Len() > 0 {
+		k := storage.SeriesRef(d.Uvarint64())
+		mint := d.Varint64()
+		maxt := d.Varint64()
+		if d.Err() != nil {
+			return nil, d.Err()
+		}
+		stonesMap.AddInterval(k, Interval{mint, maxt})
+	}
+	return stonesMap, nil
+}
+
+// v2Codec groups intervals per series with a single ref prefix and
+// delta-varint (mint, maxt) pairs, optionally zstd-compressing the result,
+// and appends a footer of per-series offsets so Get can seek directly to a
+// series instead of scanning the whole payload. The footer offsets are into
+// the (decompressed) per-series section; when Compress is enabled that
+// section is decompressed once, in full, on read, trading the ability to
+// seek an on-disk byte range for a smaller file.
+type v2Codec struct {
+	// Compress zstd-compresses the per-series section when set.
+	Compress bool
+}
+
+// NewV2Codec returns the v2 Codec, optionally zstd-compressing the payload.
+func NewV2Codec(compress bool) Codec {
+	return &v2Codec{Compress: compress}
+}
+
+func (c *v2Codec) Format() byte { return tombstoneFormatV2 }
+
+type v2SeriesEntry struct {
+	ref storage.SeriesRef
+	ivs Intervals
+}
+
+func (c *v2Codec) EncodeBody(tr Reader) ([]byte, error) {
+	var entries []v2SeriesEntry
+	if err := tr.Iter(func(ref storage.SeriesRef, ivs Intervals) error {
+		entries = append(entries, v2SeriesEntry{ref: ref, ivs: ivs})
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ref < entries[j].ref })
+
+	main := encoding.Encbuf{}
+	footer := encoding.Encbuf{}
+	footer.PutUvarint64(uint64(len(entries)))
+
+	prevRef := storage.SeriesRef(0)
+	for _, e := range entries {
+		footer.PutUvarint64(uint64(e.ref - prevRef))
+		footer.PutUvarint64(uint64(main.Len()))
+		footer.PutUvarint64(uint64(len(e.ivs)))
+		prevRef = e.ref
+
+		prevEnd := int64(0)
+		for _, iv := range e.ivs {
+			main.PutVarint64(iv.Mint - prevEnd)
+			main.PutUvarint64(uint64(iv.Maxt - iv.Mint))
+			prevEnd = iv.Maxt
+		}
+	}
+
+	mainBytes := main.Get()
+	compressFlag := byte(tombstoneCompressionNone)
+	if c.Compress {
+		compressed, err := zstdCompress(mainBytes)
+		if err != nil {
+			return nil, fmt.Errorf("compress tombstones: %w", err)
+		}
+		mainBytes = compressed
+		compressFlag = tombstoneCompressionZstd
+	}
+
+	out := make([]byte, 0, 1+len(mainBytes)+footer.Len()+8)
+	out = append(out, compressFlag)
+	out = append(out, mainBytes...)
+	footerOffset := uint64(len(mainBytes))
+	out = append(out, footer.Get()...)
+	var trailer [8]byte
+	binary.BigEndian.PutUint64(trailer[:], footerOffset)
+	out = append(out, trailer[:]...)
+	return out, nil
+}
+
+func (c *v2Codec) DecodeBody(b []byte) (Reader, error) {
+	if len(b) < 1+8 {
+		return nil, fmt.Errorf("tombstone v2 payload too small")
+	}
+	compressFlag := b[0]
+	rest := b[1:]
+
+	trailer := rest[len(rest)-8:]
+	footerOffset := binary.BigEndian.Uint64(trailer)
+	if footerOffset > uint64(len(rest)-8) {
+		return nil, fmt.Errorf("tombstone v2 footer offset out of range")
+	}
+	mainBytes := rest[:footerOffset]
+	footerBytes := rest[footerOffset : len(rest)-8]
+
+	switch compressFlag {
+	case tombstoneCompressionNone:
+	case tombstoneCompressionZstd:
+		decompressed, err := zstdDecompress(mainBytes)
+		if err != nil {
+			return nil, fmt.Errorf("decompress tombstones: %w", err)
+		}
+		mainBytes = decompressed
+	default:
+		return nil, fmt.Errorf("invalid tombstone v2 compression flag %x", compressFlag)
+	}
+
+	fd := &encoding.Decbuf{B: footerBytes}
+	n := fd.Uvarint64()
+	offsets := make(map[storage.SeriesRef]v2FooterEntry, n)
+	order := make([]storage.SeriesRef, 0, n)
+	ref := storage.SeriesRef(0)
+	for i := uint64(0); i < n; i++ {
+		ref += storage.SeriesRef(fd.Uvarint64())
+		offset := fd.Uvarint64()
+		count := fd.Uvarint64()
+		offsets[ref] = v2FooterEntry{offset: int(offset), count: int(count)}
+		order = append(order, ref)
+	}
+	if fd.Err() != nil {
+		return nil, fd.Err()
+	}
+
+	return &v2Reader{body: mainBytes, offsets: offsets, order: order}, nil
+}
+
+type v2FooterEntry struct {
+	offset int
+	count  int
+}
+
+// v2Reader serves Get/Iter/Total directly off the decoded v2 payload using
+// the footer's per-series offsets, without re-walking unrelated series.
+type v2Reader struct {
+	body    []byte
+	offsets map[storage.SeriesRef]v2FooterEntry
+	order   []storage.SeriesRef
+}
+
+func (r *v2Reader) Get(ref storage.SeriesRef) (Intervals, error) {
+	e, ok := r.offsets[ref]
+	if !ok {
+		return nil, nil
+	}
+	d := &encoding.Decbuf{B: r.body[e.offset:]}
+	ivs := make(Intervals, 0, e.count)
+	prevEnd := int64(0)
+	for i := 0; i < e.count; i++ {
+		mint := prevEnd + d.Varint64()
+		maxt := mint + int64(d.Uvarint64())
+		ivs = append(ivs, Interval{Mint: mint, Maxt: maxt})
+		prevEnd = maxt
+	}
+	if d.Err() != nil {
+		return nil, d.Err()
+	}
+	return ivs, nil
+}
+
+func (r *v2Reader) Iter(f func(storage.SeriesRef, Intervals) error) error {
+	for _, ref := range r.order {
+		ivs, err := r.Get(ref)
+		if err != nil {
+			return err
+		}
+		if err := f(ref, ivs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *v2Reader) Total() uint64 {
+	var total uint64
+	for _, e := range r.offsets {
+		total += uint64(e.count)
+	}
+	return total
+}
+
+func (*v2Reader) Close() error { return nil }
+
+func zstdCompress(b []byte) ([]byte, error) {
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		return nil, err
+	}
+	defer enc.Close()
+	return enc.EncodeAll(b, make([]byte, 0, len(b))), nil
+}
+
+func zstdDecompress(b []byte) ([]byte, error) {
+	dec, err := zstd.NewReader(nil)
+	if err != nil {
+		return nil, err
+	}
+	defer dec.Close()
+	return dec.DecodeAll(b, nil)
+}
@@ -0,0 +1,79 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tombstones
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/prometheus/prometheus/storage"
+)
+
+// benchTombstones builds nSeries series with nPerSeries non-overlapping
+// intervals each, representative of a block with millions of stones.
+func benchTombstones(nSeries, nPerSeries int) *MemTombstones {
+	m := NewMemTombstones()
+	for s := 0; s < nSeries; s++ {
+		ref := storage.SeriesRef(s + 1)
+		for i := 0; i < nPerSeries; i++ {
+			base := int64(i * 100)
+			m.AddInterval(ref, Interval{Mint: base, Maxt: base + 10})
+		}
+	}
+	return m
+}
+
+func BenchmarkEncode(b *testing.B) {
+	m := benchTombstones(100000, 10)
+	codecs := map[string]Codec{
+		"v1":      v1Codec{},
+		"v2":      NewV2Codec(false),
+		"v2_zstd": NewV2Codec(true),
+	}
+	for name, codec := range codecs {
+		b.Run(name, func(b *testing.B) {
+			var size int
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				out, err := EncodeWithCodec(codec, m)
+				require.NoError(b, err)
+				size = len(out)
+			}
+			b.ReportMetric(float64(size), "bytes/op")
+		})
+	}
+}
+
+func BenchmarkGet(b *testing.B) {
+	m := benchTombstones(100000, 10)
+	codecs := map[string]Codec{
+		"v1":      v1Codec{},
+		"v2":      NewV2Codec(false),
+		"v2_zstd": NewV2Codec(true),
+	}
+	for name, codec := range codecs {
+		encoded, err := EncodeWithCodec(codec, m)
+		require.NoError(b, err)
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				r, err := Decode(encoded)
+				require.NoError(b, err)
+				_, err = r.Get(storage.SeriesRef(5000))
+				require.NoError(b, err)
+			}
+		})
+	}
+}
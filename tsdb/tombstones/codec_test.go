@@ -0,0 +1,76 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tombstones
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/prometheus/prometheus/storage"
+)
+
+func TestCodecRoundtrip(t *testing.T) {
+	src := NewMemTombstones()
+	src.AddInterval(1, Interval{Mint: 1, Maxt: 10})
+	src.AddInterval(1, Interval{Mint: 20, Maxt: 30})
+	src.AddInterval(2, Interval{Mint: 5, Maxt: 15})
+
+	for name, codec := range map[string]Codec{
+		"v1":      v1Codec{},
+		"v2":      NewV2Codec(false),
+		"v2_zstd": NewV2Codec(true),
+	} {
+		t.Run(name, func(t *testing.T) {
+			encoded, err := EncodeWithCodec(codec, src)
+			require.NoError(t, err)
+			require.Equal(t, codec.Format(), encoded[0])
+
+			got, err := Decode(encoded)
+			require.NoError(t, err)
+			require.Equal(t, src.Total(), got.Total())
+
+			ivs, err := got.Get(1)
+			require.NoError(t, err)
+			require.Equal(t, Intervals{{Mint: 1, Maxt: 10}, {Mint: 20, Maxt: 30}}, ivs)
+
+			ivs, err = got.Get(2)
+			require.NoError(t, err)
+			require.Equal(t, Intervals{{Mint: 5, Maxt: 15}}, ivs)
+
+			ivs, err = got.Get(3)
+			require.NoError(t, err)
+			require.Empty(t, ivs)
+		})
+	}
+}
+
+func TestDecodeRejectsUnknownFormat(t *testing.T) {
+	_, err := Decode([]byte{0xFF})
+	require.Error(t, err)
+}
+
+func TestV1ReadsBackFromExistingEncode(t *testing.T) {
+	src := NewMemTombstones()
+	src.AddInterval(42, Interval{Mint: 1, Maxt: 2})
+
+	encoded, err := Encode(src)
+	require.NoError(t, err)
+
+	got, err := Decode(encoded)
+	require.NoError(t, err)
+	ivs, err := got.Get(storage.SeriesRef(42))
+	require.NoError(t, err)
+	require.Equal(t, Intervals{{Mint: 1, Maxt: 2}}, ivs)
+}
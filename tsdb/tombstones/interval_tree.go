@@ -0,0 +1,261 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tombstones
+
+import (
+	"math"
+	"math/rand"
+)
+
+// seriesTreeThreshold is the number of intervals a single series can hold in
+// the flat, slice-backed representation before Add promotes it to a treap.
+// Below this, the O(n) slice merge in Intervals.Add is cheaper in practice
+// than the pointer-chasing of a tree, since len(in) is tiny and fits in a
+// couple of cache lines.
+const seriesTreeThreshold = 64
+
+// seriesTombstones holds the deletion intervals for a single series. It
+// starts out as a flat, sorted slice (the common case, inherited from the
+// original implementation) and is promoted to an augmented treap once it
+// grows past seriesTreeThreshold, so that a hot series with many
+// non-contiguous deletes doesn't pay O(n) per Add or O(n^2) for a bulk of
+// them.
+type seriesTombstones struct {
+	flat Intervals
+	tree *intervalTreap
+}
+
+// newSeriesTombstones builds a seriesTombstones from an already sorted,
+// disjoint set of intervals, picking the flat or tree representation based
+// on its size.
+func newSeriesTombstones(ivs Intervals) *seriesTombstones {
+	if len(ivs) <= seriesTreeThreshold {
+		return &seriesTombstones{flat: ivs}
+	}
+	s := &seriesTombstones{tree: newIntervalTreap()}
+	for _, iv := range ivs {
+		s.tree.insertDisjoint(iv)
+	}
+	return s
+}
+
+// Add merges n into the set, same semantics as Intervals.Add.
+func (s *seriesTombstones) Add(n Interval) {
+	if s.tree != nil {
+		s.tree.Add(n)
+		return
+	}
+	s.flat = s.flat.Add(n)
+	if len(s.flat) > seriesTreeThreshold {
+		s.tree = newIntervalTreap()
+		for _, iv := range s.flat {
+			s.tree.insertDisjoint(iv)
+		}
+		s.flat = nil
+	}
+}
+
+// Intervals returns a copy of the set's intervals in ascending order.
+func (s *seriesTombstones) Intervals() Intervals {
+	if s.tree != nil {
+		return s.tree.InOrder()
+	}
+	out := make(Intervals, len(s.flat))
+	copy(out, s.flat)
+	return out
+}
+
+// Len returns the number of intervals currently held.
+func (s *seriesTombstones) Len() int {
+	if s.tree != nil {
+		return s.tree.n
+	}
+	return len(s.flat)
+}
+
+// treapNode is a node of an intervalTreap, keyed by Interval.Mint.
+type treapNode struct {
+	iv          Interval
+	priority    uint64
+	left, right *treapNode
+	maxMaxt     int64 // max Maxt across the subtree rooted at this node.
+}
+
+func (n *treapNode) update() {
+	n.maxMaxt = n.iv.Maxt
+	if n.left != nil && n.left.maxMaxt > n.maxMaxt {
+		n.maxMaxt = n.left.maxMaxt
+	}
+	if n.right != nil && n.right.maxMaxt > n.maxMaxt {
+		n.maxMaxt = n.right.maxMaxt
+	}
+}
+
+// intervalTreap is an augmented treap over a set of disjoint, non-adjacent
+// intervals ordered by Mint. It supports Add with automatic merging of
+// overlapping or touching intervals in O(log n + k), where k is the number
+// of existing intervals the new one absorbs.
+type intervalTreap struct {
+	root *treapNode
+	rnd  *rand.Rand
+	n    int
+}
+
+func newIntervalTreap() *intervalTreap {
+	return &intervalTreap{rnd: rand.New(rand.NewSource(rand.Int63()))}
+}
+
+func splitByMint(t *treapNode, key int64) (*treapNode, *treapNode) {
+	if t == nil {
+		return nil, nil
+	}
+	if t.iv.Mint < key {
+		l, r := splitByMint(t.right, key)
+		t.right = l
+		t.update()
+		return t, r
+	}
+	l, r := splitByMint(t.left, key)
+	t.left = r
+	t.update()
+	return l, t
+}
+
+// mergeTreaps joins two treaps whose keys are known not to interleave (every
+// key in a is less than every key in b).
+func mergeTreaps(a, b *treapNode) *treapNode {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	if a.priority > b.priority {
+		a.right = mergeTreaps(a.right, b)
+		a.update()
+		return a
+	}
+	b.left = mergeTreaps(a, b.left)
+	b.update()
+	return b
+}
+
+func insertNode(t, n *treapNode) *treapNode {
+	if t == nil {
+		return n
+	}
+	if n.priority > t.priority {
+		l, r := splitByMint(t, n.iv.Mint)
+		n.left, n.right = l, r
+		n.update()
+		return n
+	}
+	if n.iv.Mint < t.iv.Mint {
+		t.left = insertNode(t.left, n)
+	} else {
+		t.right = insertNode(t.right, n)
+	}
+	t.update()
+	return t
+}
+
+func deleteByMint(t *treapNode, key int64) *treapNode {
+	if t == nil {
+		return nil
+	}
+	switch {
+	case key < t.iv.Mint:
+		t.left = deleteByMint(t.left, key)
+	case key > t.iv.Mint:
+		t.right = deleteByMint(t.right, key)
+	default:
+		return mergeTreaps(t.left, t.right)
+	}
+	t.update()
+	return t
+}
+
+// insertDisjoint inserts iv without checking for overlaps. Callers must
+// guarantee iv doesn't overlap or touch any interval already in the treap,
+// e.g. when bulk-loading an already-merged, sorted set of intervals.
+func (t *intervalTreap) insertDisjoint(iv Interval) {
+	t.root = insertNode(t.root, &treapNode{iv: iv, priority: t.rnd.Uint64(), maxMaxt: iv.Maxt})
+	t.n++
+}
+
+// Add merges n into the treap, absorbing and removing any interval that
+// overlaps or is adjacent to it, the same semantics as Intervals.Add.
+func (t *intervalTreap) Add(n Interval) {
+	overlapping := t.stab(n)
+	merged := n
+	for _, o := range overlapping {
+		if o.Mint < merged.Mint {
+			merged.Mint = o.Mint
+		}
+		if o.Maxt > merged.Maxt {
+			merged.Maxt = o.Maxt
+		}
+		t.root = deleteByMint(t.root, o.Mint)
+		t.n--
+	}
+	t.insertDisjoint(merged)
+}
+
+// stab returns every interval in the treap that overlaps or touches n. The
+// treap's keys are sorted and disjoint, so the result is contiguous in key
+// order; maxMaxt lets it skip subtrees that can't reach back far enough to
+// matter.
+func (t *intervalTreap) stab(n Interval) []Interval {
+	var out []Interval
+	lo := n.Mint
+	if lo != math.MinInt64 {
+		lo--
+	}
+	hi := n.Maxt
+	if hi != math.MaxInt64 {
+		hi++
+	}
+
+	var walk func(*treapNode)
+	walk = func(nd *treapNode) {
+		if nd == nil || nd.maxMaxt < lo {
+			return
+		}
+		walk(nd.left)
+		if nd.iv.Mint <= hi && nd.iv.Maxt >= lo {
+			out = append(out, nd.iv)
+		}
+		if nd.iv.Mint <= hi {
+			walk(nd.right)
+		}
+	}
+	walk(t.root)
+	return out
+}
+
+// InOrder returns every interval held by the treap, in ascending order.
+func (t *intervalTreap) InOrder() Intervals {
+	out := make(Intervals, 0, t.n)
+	var walk func(*treapNode)
+	walk = func(nd *treapNode) {
+		if nd == nil {
+			return
+		}
+		walk(nd.left)
+		out = append(out, nd.iv)
+		walk(nd.right)
+	}
+	walk(t.root)
+	return out
+}
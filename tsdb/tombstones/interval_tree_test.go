@@ -0,0 +1,90 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tombstones
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeriesTombstonesPromotesToTree(t *testing.T) {
+	s := newSeriesTombstones(nil)
+	for i := 0; i < seriesTreeThreshold+10; i++ {
+		base := int64(i * 100)
+		s.Add(Interval{Mint: base, Maxt: base + 10})
+	}
+	require.NotNil(t, s.tree)
+	require.Nil(t, s.flat)
+	require.Equal(t, seriesTreeThreshold+10, s.Len())
+}
+
+func randomOps(rnd *rand.Rand, n int) []Interval {
+	ops := make([]Interval, n)
+	for i := range ops {
+		mint := rnd.Int63n(1000)
+		ops[i] = Interval{Mint: mint, Maxt: mint + rnd.Int63n(20)}
+	}
+	return ops
+}
+
+// FuzzIntervalTreapMatchesSlice cross-checks intervalTreap.Add against the
+// existing slice-based Intervals.Add on random workloads: both must end up
+// with the same merged, sorted set of intervals.
+func FuzzIntervalTreapMatchesSlice(f *testing.F) {
+	f.Add(int64(1), 50)
+	f.Add(int64(42), 200)
+	f.Fuzz(func(t *testing.T, seed int64, n int) {
+		if n <= 0 || n > 500 {
+			n = (n%500 + 500) % 500
+			if n == 0 {
+				n = 1
+			}
+		}
+		rnd := rand.New(rand.NewSource(seed))
+
+		var want Intervals
+		tree := newIntervalTreap()
+		for _, iv := range randomOps(rnd, n) {
+			want = want.Add(iv)
+			tree.Add(iv)
+		}
+		require.Equal(t, want, tree.InOrder())
+	})
+}
+
+// FuzzSeriesTombstonesMatchesSlice cross-checks the hybrid
+// flat/tree seriesTombstones against plain Intervals.Add across the
+// promotion boundary.
+func FuzzSeriesTombstonesMatchesSlice(f *testing.F) {
+	f.Add(int64(7), 150)
+	f.Fuzz(func(t *testing.T, seed int64, n int) {
+		if n <= 0 || n > 500 {
+			n = (n%500 + 500) % 500
+			if n == 0 {
+				n = 1
+			}
+		}
+		rnd := rand.New(rand.NewSource(seed))
+
+		var want Intervals
+		s := newSeriesTombstones(nil)
+		for _, iv := range randomOps(rnd, n) {
+			want = want.Add(iv)
+			s.Add(iv)
+		}
+		require.Equal(t, want, s.Intervals())
+	})
+}
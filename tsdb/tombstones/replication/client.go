@@ -0,0 +1,107 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package replication
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/tombstones"
+	"github.com/prometheus/prometheus/tsdb/tombstones/replication/replicationpb"
+)
+
+// Update is a single message a Replicator delivers to its caller: either a
+// full-snapshot Stone or an incremental Delta, mirroring SyncResponse.
+type Update struct {
+	Snapshot *tombstones.Stone
+	Delta    *tombstones.Delta
+}
+
+// Replicator drives a Sync call against a peer, resuming from the last seen
+// revision across reconnects.
+type Replicator struct {
+	client      replicationpb.TombstoneReplicationClient
+	resumeToken uint64
+}
+
+// NewReplicator returns a Replicator that syncs from client, starting from a
+// full snapshot. Call ResumeToken after a Run returns to persist the point
+// to resume from on the next call.
+func NewReplicator(client replicationpb.TombstoneReplicationClient) *Replicator {
+	return &Replicator{client: client}
+}
+
+// ResumeToken returns the revision of the last delta delivered, suitable for
+// passing back in so a reconnect resumes from where Run left off. Snapshot
+// records don't advance it, so a Run that ends part-way through a snapshot
+// fetches the snapshot again on the next call instead of skipping the rest.
+func (r *Replicator) ResumeToken() uint64 {
+	return r.resumeToken
+}
+
+// SetResumeToken seeds the revision to resume from on the next Run, e.g.
+// after restoring it from a checkpoint.
+func (r *Replicator) SetResumeToken(revision uint64) {
+	r.resumeToken = revision
+}
+
+// Run streams updates from the peer into updates until the stream ends or
+// ctx is canceled. It is safe to call Run again after it returns: it will
+// resume from r.ResumeToken() rather than re-fetching a full snapshot.
+func (r *Replicator) Run(ctx context.Context, updates chan<- Update) error {
+	stream, err := r.client.Sync(ctx, &replicationpb.SyncRequest{ResumeRevision: r.resumeToken})
+	if err != nil {
+		return fmt.Errorf("start tombstone sync: %w", err)
+	}
+
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+
+		var update Update
+		switch {
+		case resp.Snapshot != nil:
+			ivs := make(tombstones.Intervals, len(resp.Snapshot.Intervals))
+			for i, iv := range resp.Snapshot.Intervals {
+				ivs[i] = tombstones.Interval{Mint: iv.Mint, Maxt: iv.Maxt}
+			}
+			update = Update{Snapshot: &tombstones.Stone{
+				Ref:       storage.SeriesRef(resp.Snapshot.Ref),
+				Intervals: ivs,
+			}}
+		case resp.Delta != nil:
+			update = Update{Delta: &tombstones.Delta{
+				Revision: resp.Revision,
+				Event: tombstones.TombstoneEvent{
+					SeriesRef: storage.SeriesRef(resp.Delta.Ref),
+					Mint:      resp.Delta.Mint,
+					Maxt:      resp.Delta.Maxt,
+					Op:        tombstones.EventOp(resp.Delta.Op),
+				},
+			}}
+		}
+
+		select {
+		case updates <- update:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if resp.Delta != nil {
+			r.resumeToken = resp.Revision
+		}
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package replication
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+
+	"github.com/prometheus/prometheus/tsdb/tombstones/replication/replicationpb"
+)
+
+// fakeSyncClient implements replicationpb.TombstoneReplication_SyncClient by
+// yielding a fixed sequence of responses from Recv, one per call.
+type fakeSyncClient struct {
+	grpc.ClientStream
+
+	resps []*replicationpb.SyncResponse
+	i     int
+}
+
+func (c *fakeSyncClient) Recv() (*replicationpb.SyncResponse, error) {
+	if c.i >= len(c.resps) {
+		<-make(chan struct{}) // block forever, like a live stream with no more messages yet
+	}
+	resp := c.resps[c.i]
+	c.i++
+	return resp, nil
+}
+
+type fakeReplicationClient struct {
+	stream replicationpb.TombstoneReplication_SyncClient
+}
+
+func (c *fakeReplicationClient) Sync(context.Context, *replicationpb.SyncRequest, ...grpc.CallOption) (replicationpb.TombstoneReplication_SyncClient, error) {
+	return c.stream, nil
+}
+
+// TestReplicatorRunReturnsWhenContextCanceledWhileUpdatesBlocked verifies
+// that Run doesn't leak a goroutine blocked forever on an unbuffered
+// updates channel the consumer has stopped draining: canceling ctx must
+// unblock the send and return promptly.
+func TestReplicatorRunReturnsWhenContextCanceledWhileUpdatesBlocked(t *testing.T) {
+	stream := &fakeSyncClient{resps: []*replicationpb.SyncResponse{
+		{Delta: &replicationpb.DeltaProto{Ref: 1}, Revision: 1},
+	}}
+	r := NewReplicator(&fakeReplicationClient{stream: stream})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	updates := make(chan Update) // unbuffered and never drained, like an abandoned consumer
+
+	done := make(chan error, 1)
+	go func() { done <- r.Run(ctx, updates) }()
+
+	cancel()
+
+	select {
+	case err := <-done:
+		require.ErrorIs(t, err, context.Canceled)
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after ctx was canceled; send on updates is not cancellation-aware")
+	}
+}
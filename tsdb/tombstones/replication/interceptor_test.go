@@ -0,0 +1,58 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package replication
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRecoveryStreamServerInterceptorConvertsPanicToStatusError(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	interceptor := RecoveryStreamServerInterceptor(logger)
+
+	var stream grpc.ServerStream // nil: the panicking handler below never touches it
+	info := &grpc.StreamServerInfo{FullMethod: "/replicationpb.TombstoneReplication/Sync"}
+	handler := func(srv interface{}, ss grpc.ServerStream) error {
+		panic("boom")
+	}
+
+	var err error
+	require.NotPanics(t, func() {
+		err = interceptor(nil, stream, info, handler)
+	})
+
+	require.Error(t, err)
+	require.Equal(t, codes.Internal, status.Code(err))
+}
+
+func TestRecoveryStreamServerInterceptorPassesThroughNormalResult(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	interceptor := RecoveryStreamServerInterceptor(logger)
+
+	var stream grpc.ServerStream
+	info := &grpc.StreamServerInfo{FullMethod: "/replicationpb.TombstoneReplication/Sync"}
+	handler := func(srv interface{}, ss grpc.ServerStream) error {
+		return status.Error(codes.Unavailable, "peer gone")
+	}
+
+	err := interceptor(nil, stream, info, handler)
+	require.Equal(t, codes.Unavailable, status.Code(err))
+}
@@ -0,0 +1,82 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package replicationpb holds the message types described by
+// replication.proto. They are hand-written, not generated: this tree has no
+// protoc-gen-go toolchain step, so whoever edits replication.proto must keep
+// these types in sync by hand, and the protobuf struct tags below are
+// documentation only, not backed by real Marshal/Unmarshal or ProtoReflect
+// support.
+package replicationpb
+
+import "fmt"
+
+type IntervalProto struct {
+	Mint int64 `protobuf:"varint,1,opt,name=mint,proto3" json:"mint,omitempty"`
+	Maxt int64 `protobuf:"varint,2,opt,name=maxt,proto3" json:"maxt,omitempty"`
+}
+
+func (m *IntervalProto) Reset()         { *m = IntervalProto{} }
+func (m *IntervalProto) String() string { return fmt.Sprintf("mint:%d maxt:%d", m.Mint, m.Maxt) }
+func (*IntervalProto) ProtoMessage()    {}
+
+type StoneProto struct {
+	Ref       uint64           `protobuf:"varint,1,opt,name=ref,proto3" json:"ref,omitempty"`
+	Intervals []*IntervalProto `protobuf:"bytes,2,rep,name=intervals,proto3" json:"intervals,omitempty"`
+}
+
+func (m *StoneProto) Reset() { *m = StoneProto{} }
+func (m *StoneProto) String() string {
+	return fmt.Sprintf("ref:%d intervals:%d", m.Ref, len(m.Intervals))
+}
+func (*StoneProto) ProtoMessage() {}
+
+// SyncRequest starts a sync session. A zero ResumeRevision requests a full
+// snapshot; any other value asks the peer to resume from that revision,
+// falling back to a full snapshot if it has already been pruned.
+type SyncRequest struct {
+	ResumeRevision uint64 `protobuf:"varint,1,opt,name=resume_revision,json=resumeRevision,proto3" json:"resume_revision,omitempty"`
+}
+
+func (m *SyncRequest) Reset() { *m = SyncRequest{} }
+func (m *SyncRequest) String() string {
+	return fmt.Sprintf("resume_revision:%d", m.ResumeRevision)
+}
+func (*SyncRequest) ProtoMessage() {}
+
+type DeltaProto struct {
+	Ref  uint64 `protobuf:"varint,1,opt,name=ref,proto3" json:"ref,omitempty"`
+	Mint int64  `protobuf:"varint,2,opt,name=mint,proto3" json:"mint,omitempty"`
+	Maxt int64  `protobuf:"varint,3,opt,name=maxt,proto3" json:"maxt,omitempty"`
+	Op   int32  `protobuf:"varint,4,opt,name=op,proto3" json:"op,omitempty"`
+}
+
+func (m *DeltaProto) Reset() { *m = DeltaProto{} }
+func (m *DeltaProto) String() string {
+	return fmt.Sprintf("ref:%d mint:%d maxt:%d op:%d", m.Ref, m.Mint, m.Maxt, m.Op)
+}
+func (*DeltaProto) ProtoMessage() {}
+
+// SyncResponse carries either a snapshot record (full sync) or an
+// incremental delta (resumed sync), never both.
+type SyncResponse struct {
+	Snapshot *StoneProto `protobuf:"bytes,1,opt,name=snapshot,proto3" json:"snapshot,omitempty"`
+	Delta    *DeltaProto `protobuf:"bytes,2,opt,name=delta,proto3" json:"delta,omitempty"`
+	Revision uint64      `protobuf:"varint,3,opt,name=revision,proto3" json:"revision,omitempty"`
+}
+
+func (m *SyncResponse) Reset() { *m = SyncResponse{} }
+func (m *SyncResponse) String() string {
+	return fmt.Sprintf("snapshot:%v delta:%v revision:%d", m.Snapshot, m.Delta, m.Revision)
+}
+func (*SyncResponse) ProtoMessage() {}
@@ -0,0 +1,134 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Hand-written client/server stubs for the TombstoneReplication service
+// described by replication.proto. There is no protoc-gen-go-grpc step in
+// this tree; keep this file in sync with replication.proto by hand when the
+// service definition changes.
+package replicationpb
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// TombstoneReplicationClient is the client API for TombstoneReplication.
+type TombstoneReplicationClient interface {
+	Sync(ctx context.Context, in *SyncRequest, opts ...grpc.CallOption) (TombstoneReplication_SyncClient, error)
+}
+
+type tombstoneReplicationClient struct {
+	cc grpc.ClientConnInterface
+}
+
+// NewTombstoneReplicationClient wraps cc in a TombstoneReplicationClient.
+func NewTombstoneReplicationClient(cc grpc.ClientConnInterface) TombstoneReplicationClient {
+	return &tombstoneReplicationClient{cc}
+}
+
+func (c *tombstoneReplicationClient) Sync(ctx context.Context, in *SyncRequest, opts ...grpc.CallOption) (TombstoneReplication_SyncClient, error) {
+	stream, err := c.cc.NewStream(ctx, &TombstoneReplication_ServiceDesc.Streams[0], "/prometheus.tsdb.tombstones.replication.TombstoneReplication/Sync", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &tombstoneReplicationSyncClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// TombstoneReplication_SyncClient is returned by Sync and yields one
+// SyncResponse per Recv call.
+type TombstoneReplication_SyncClient interface {
+	Recv() (*SyncResponse, error)
+	grpc.ClientStream
+}
+
+type tombstoneReplicationSyncClient struct {
+	grpc.ClientStream
+}
+
+func (x *tombstoneReplicationSyncClient) Recv() (*SyncResponse, error) {
+	m := new(SyncResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// TombstoneReplicationServer is the server API for TombstoneReplication.
+type TombstoneReplicationServer interface {
+	Sync(*SyncRequest, TombstoneReplication_SyncServer) error
+	mustEmbedUnimplementedTombstoneReplicationServer()
+}
+
+// UnimplementedTombstoneReplicationServer must be embedded in every server
+// implementation for forward compatibility with new methods.
+type UnimplementedTombstoneReplicationServer struct{}
+
+func (UnimplementedTombstoneReplicationServer) Sync(*SyncRequest, TombstoneReplication_SyncServer) error {
+	return status.Errorf(codes.Unimplemented, "method Sync not implemented")
+}
+
+func (UnimplementedTombstoneReplicationServer) mustEmbedUnimplementedTombstoneReplicationServer() {}
+
+// TombstoneReplication_SyncServer is the server-side stream handed to
+// TombstoneReplicationServer.Sync.
+type TombstoneReplication_SyncServer interface {
+	Send(*SyncResponse) error
+	grpc.ServerStream
+}
+
+type tombstoneReplicationSyncServer struct {
+	grpc.ServerStream
+}
+
+func (x *tombstoneReplicationSyncServer) Send(m *SyncResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _TombstoneReplication_Sync_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SyncRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(TombstoneReplicationServer).Sync(m, &tombstoneReplicationSyncServer{stream})
+}
+
+// RegisterTombstoneReplicationServer registers srv on s.
+func RegisterTombstoneReplicationServer(s grpc.ServiceRegistrar, srv TombstoneReplicationServer) {
+	s.RegisterService(&TombstoneReplication_ServiceDesc, srv)
+}
+
+// TombstoneReplication_ServiceDesc is the grpc.ServiceDesc for
+// TombstoneReplication.
+var TombstoneReplication_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "prometheus.tsdb.tombstones.replication.TombstoneReplication",
+	HandlerType: (*TombstoneReplicationServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Sync",
+			Handler:       _TombstoneReplication_Sync_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "replication.proto",
+}
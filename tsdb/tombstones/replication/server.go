@@ -0,0 +1,123 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package replication implements peering-style replication of a single
+// tombstones.MemTombstones to remote peers, inspired by Consul's peering
+// initial-sync design: a new peer receives a full snapshot and then follows
+// along via small incremental deltas keyed by a monotonic revision, so it
+// can resume after a disconnect without re-reading everything.
+package replication
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/prometheus/prometheus/tsdb/tombstones"
+	"github.com/prometheus/prometheus/tsdb/tombstones/replication/replicationpb"
+)
+
+// Source is the subset of *tombstones.MemTombstones a Server replicates
+// from: a point-in-time Snapshot for the initial sync, the revision log for
+// incremental catch-up, and Wait to block between batches of deltas instead
+// of polling.
+type Source interface {
+	// Snapshot returns a copy of every series' intervals as of some
+	// revision, safe to consume (e.g. stream out over a slow connection)
+	// without holding any lock on the underlying store.
+	Snapshot() (stones []tombstones.Stone, revision uint64)
+	Revision() uint64
+	DeltasSince(since uint64) (deltas []tombstones.Delta, ok bool)
+	// Wait blocks until the revision advances past since or ctx is done,
+	// returning false in the latter case.
+	Wait(ctx context.Context, since uint64) bool
+}
+
+// Server implements replicationpb.TombstoneReplicationServer over a Source.
+type Server struct {
+	replicationpb.UnimplementedTombstoneReplicationServer // reserved for forward-compatible method additions
+
+	src Source
+}
+
+// NewServer returns a Server that replicates src to any peer that calls Sync.
+func NewServer(src Source) *Server {
+	return &Server{src: src}
+}
+
+// Sync streams a full snapshot of the Source's current tombstones, then
+// blocks and streams live incremental deltas as they're recorded until the
+// client disconnects or its context is canceled. If req.ResumeRevision is
+// non-zero and still covered by the Source's retained delta log, the
+// snapshot is skipped and the stream resumes directly from that revision.
+func (s *Server) Sync(req *replicationpb.SyncRequest, stream replicationpb.TombstoneReplication_SyncServer) error {
+	ctx := stream.Context()
+	resumeFrom := req.ResumeRevision
+	if resumeFrom == 0 {
+		var err error
+		if resumeFrom, err = s.sendSnapshot(stream); err != nil {
+			return err
+		}
+	}
+
+	for {
+		deltas, ok := s.src.DeltasSince(resumeFrom)
+		if !ok {
+			// The requested resume point has been pruned from the delta log;
+			// fall back to a fresh snapshot on this same stream.
+			var err error
+			if resumeFrom, err = s.sendSnapshot(stream); err != nil {
+				return err
+			}
+			deltas, _ = s.src.DeltasSince(resumeFrom)
+		}
+
+		for _, d := range deltas {
+			if err := stream.Send(&replicationpb.SyncResponse{
+				Delta: &replicationpb.DeltaProto{
+					Ref:  uint64(d.Event.SeriesRef),
+					Mint: d.Event.Mint,
+					Maxt: d.Event.Maxt,
+					Op:   int32(d.Event.Op),
+				},
+				Revision: d.Revision,
+			}); err != nil {
+				return fmt.Errorf("send tombstone delta: %w", err)
+			}
+			resumeFrom = d.Revision
+		}
+
+		if !s.src.Wait(ctx, resumeFrom) {
+			return ctx.Err()
+		}
+	}
+}
+
+// sendSnapshot streams a point-in-time copy of the Source's tombstones,
+// taken without holding any lock on it for the duration of the (potentially
+// slow) stream, and returns the revision the copy was taken at.
+func (s *Server) sendSnapshot(stream replicationpb.TombstoneReplication_SyncServer) (uint64, error) {
+	stones, revision := s.src.Snapshot()
+	for _, st := range stones {
+		pbIvs := make([]*replicationpb.IntervalProto, len(st.Intervals))
+		for i, iv := range st.Intervals {
+			pbIvs[i] = &replicationpb.IntervalProto{Mint: iv.Mint, Maxt: iv.Maxt}
+		}
+		if err := stream.Send(&replicationpb.SyncResponse{
+			Snapshot: &replicationpb.StoneProto{Ref: uint64(st.Ref), Intervals: pbIvs},
+			Revision: revision,
+		}); err != nil {
+			return 0, fmt.Errorf("send tombstone snapshot: %w", err)
+		}
+	}
+	return revision, nil
+}
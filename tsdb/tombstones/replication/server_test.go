@@ -0,0 +1,145 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package replication
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/tombstones"
+	"github.com/prometheus/prometheus/tsdb/tombstones/replication/replicationpb"
+)
+
+// fakeSyncServer implements replicationpb.TombstoneReplication_SyncServer
+// in-process, without a real gRPC transport: Sync only calls Context and
+// Send on the stream it's handed, so embedding a nil grpc.ServerStream and
+// overriding those two is enough.
+type fakeSyncServer struct {
+	grpc.ServerStream
+
+	ctx    context.Context
+	onSend func(*replicationpb.SyncResponse)
+	sent   []*replicationpb.SyncResponse
+}
+
+func (s *fakeSyncServer) Context() context.Context { return s.ctx }
+
+func (s *fakeSyncServer) Send(resp *replicationpb.SyncResponse) error {
+	s.sent = append(s.sent, resp)
+	if s.onSend != nil {
+		s.onSend(resp)
+	}
+	return nil
+}
+
+// fakeSource implements Source with a fixed snapshot and delta log, and lets
+// a test simulate a resume point that has been pruned from the log.
+type fakeSource struct {
+	stones       []tombstones.Stone
+	revision     uint64
+	deltaLog     []tombstones.Delta
+	prunedBefore uint64
+}
+
+func (f *fakeSource) Snapshot() ([]tombstones.Stone, uint64) { return f.stones, f.revision }
+
+func (f *fakeSource) Revision() uint64 { return f.revision }
+
+func (f *fakeSource) DeltasSince(since uint64) ([]tombstones.Delta, bool) {
+	if since < f.prunedBefore {
+		return nil, false
+	}
+	var out []tombstones.Delta
+	for _, d := range f.deltaLog {
+		if d.Revision > since {
+			out = append(out, d)
+		}
+	}
+	return out, true
+}
+
+// Wait blocks until ctx is done, like the real MemTombstones.Wait would for
+// a source that never gains a new revision during the test.
+func (f *fakeSource) Wait(ctx context.Context, _ uint64) bool {
+	<-ctx.Done()
+	return false
+}
+
+// TestServerSyncResumeRevisionPrunedFallsBackToSnapshot verifies that when
+// a client resumes from a revision no longer covered by the delta log, Sync
+// falls back to sending a fresh snapshot instead of erroring out.
+func TestServerSyncResumeRevisionPrunedFallsBackToSnapshot(t *testing.T) {
+	src := &fakeSource{
+		stones:       []tombstones.Stone{{Ref: storage.SeriesRef(1), Intervals: tombstones.Intervals{{Mint: 1, Maxt: 2}}}},
+		revision:     20,
+		prunedBefore: 10, // resume points before 10 are no longer retained
+	}
+	srv := NewServer(src)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream := &fakeSyncServer{ctx: ctx}
+	stream.onSend = func(resp *replicationpb.SyncResponse) {
+		if resp.Snapshot != nil {
+			// Got the fallback snapshot; end the stream the same way a
+			// client disconnect would.
+			cancel()
+		}
+	}
+
+	err := srv.Sync(&replicationpb.SyncRequest{ResumeRevision: 5}, stream)
+	require.ErrorIs(t, err, context.Canceled)
+
+	require.Len(t, stream.sent, 1, "expected exactly one fallback snapshot message, no initial snapshot and no stale-resume error")
+	require.NotNil(t, stream.sent[0].Snapshot)
+	require.Equal(t, uint64(1), stream.sent[0].Snapshot.Ref)
+	require.Equal(t, uint64(20), stream.sent[0].Revision)
+}
+
+// TestServerSyncResumesFromDeltaLogWhenRevisionRetained verifies the common
+// case: a resume revision still covered by the delta log skips the snapshot
+// entirely and streams only the missed deltas.
+func TestServerSyncResumesFromDeltaLogWhenRevisionRetained(t *testing.T) {
+	src := &fakeSource{
+		revision: 3,
+		deltaLog: []tombstones.Delta{
+			{Revision: 2, Event: tombstones.TombstoneEvent{SeriesRef: 7, Op: tombstones.OpAddInterval}},
+			{Revision: 3, Event: tombstones.TombstoneEvent{SeriesRef: 7, Op: tombstones.OpAddInterval}},
+		},
+	}
+	srv := NewServer(src)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream := &fakeSyncServer{ctx: ctx}
+	stream.onSend = func(resp *replicationpb.SyncResponse) {
+		if resp.Delta != nil && resp.Revision == 3 {
+			cancel()
+		}
+	}
+
+	err := srv.Sync(&replicationpb.SyncRequest{ResumeRevision: 1}, stream)
+	require.ErrorIs(t, err, context.Canceled)
+
+	require.Len(t, stream.sent, 2, "resume revision still in the log must not trigger a snapshot, only the two missed deltas")
+	for _, resp := range stream.sent {
+		require.Nil(t, resp.Snapshot)
+		require.NotNil(t, resp.Delta)
+	}
+	require.Equal(t, uint64(3), stream.sent[len(stream.sent)-1].Revision)
+}
@@ -0,0 +1,292 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tombstones
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/encoding"
+)
+
+// EventOp identifies the mutation a TombstoneEvent describes.
+type EventOp uint8
+
+const (
+	// OpAddInterval corresponds to MemTombstones.AddInterval.
+	OpAddInterval EventOp = iota + 1
+	// OpTruncateBefore corresponds to MemTombstones.TruncateBefore.
+	OpTruncateBefore
+	// OpDeleteTombstones corresponds to MemTombstones.DeleteTombstones.
+	OpDeleteTombstones
+)
+
+const (
+	// eventFormatV1 is the only wire format TombstoneEvent currently supports.
+	// Bumping it lets a reader reject frames it doesn't understand instead of
+	// misinterpreting them.
+	eventFormatV1 = 1
+
+	// tapDefaultBufferSize bounds the number of events a Tap will queue for a
+	// slow sink before it starts dropping them.
+	tapDefaultBufferSize = 1024
+)
+
+// TombstoneEvent is a single structured record of a tombstone mutation,
+// modeled after the dnstap message envelope: self-describing, versioned and
+// cheap to frame on the wire.
+type TombstoneEvent struct {
+	SeriesRef storage.SeriesRef
+	Mint      int64
+	Maxt      int64
+	Op        EventOp
+	BlockULID string
+	Timestamp int64 // Unix nanoseconds, set by the Tap at emission time.
+}
+
+// EncodeEvent serializes ev as a versioned, self-contained record. The
+// result does not include the length prefix used to frame it on a stream;
+// callers that write to a stream should use writeFrame.
+func EncodeEvent(ev TombstoneEvent) []byte {
+	buf := encoding.Encbuf{}
+	buf.PutByte(eventFormatV1)
+	buf.PutByte(byte(ev.Op))
+	buf.PutUvarint64(uint64(ev.SeriesRef))
+	buf.PutVarint64(ev.Mint)
+	buf.PutVarint64(ev.Maxt)
+	buf.PutVarint64(ev.Timestamp)
+	buf.PutUvarintStr(ev.BlockULID)
+	return buf.Get()
+}
+
+// DecodeEvent parses a record produced by EncodeEvent.
+func DecodeEvent(b []byte) (TombstoneEvent, error) {
+	d := &encoding.Decbuf{B: b}
+	if v := d.Byte(); v != eventFormatV1 {
+		return TombstoneEvent{}, fmt.Errorf("invalid tombstone event format %x", v)
+	}
+	ev := TombstoneEvent{
+		Op:        EventOp(d.Byte()),
+		SeriesRef: storage.SeriesRef(d.Uvarint64()),
+		Mint:      d.Varint64(),
+		Maxt:      d.Varint64(),
+		Timestamp: d.Varint64(),
+		BlockULID: d.UvarintStr(),
+	}
+	if d.Err() != nil {
+		return TombstoneEvent{}, d.Err()
+	}
+	return ev, nil
+}
+
+// writeFrame writes b to w prefixed with its length, so a reader can resync
+// on a stream without scanning for delimiters.
+func writeFrame(w io.Writer, b []byte) error {
+	var lenBuf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(lenBuf[:], uint64(len(b)))
+	if _, err := w.Write(lenBuf[:n]); err != nil {
+		return err
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+// Tap receives a copy of every tombstone mutation applied to a
+// MemTombstones. Implementations must not block the caller for long: Emit is
+// called while the MemTombstones write lock may still be held by the
+// invoking goroutine's caller, so a Tap is expected to buffer internally and
+// deliver asynchronously.
+type Tap interface {
+	// Emit queues ev for delivery. It never blocks; if the Tap is
+	// overwhelmed it drops the event and counts it as such.
+	Emit(ev TombstoneEvent)
+	// Close flushes any buffered events and releases the underlying sink.
+	Close() error
+}
+
+// Sink is the destination a Tap streams framed events to: a file, a Unix
+// socket, or a TCP connection.
+type Sink interface {
+	io.Writer
+	io.Closer
+}
+
+// TapMetrics holds the Prometheus metrics shared by all Taps created with
+// the same registerer.
+type TapMetrics struct {
+	EventsTotal   *prometheus.CounterVec
+	EventsDropped prometheus.Counter
+	QueueLength   prometheus.Gauge
+}
+
+// NewTapMetrics registers and returns the metrics used by a Tap.
+func NewTapMetrics(reg prometheus.Registerer) *TapMetrics {
+	m := &TapMetrics{
+		EventsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "prometheus_tsdb_tombstones_tap_events_total",
+			Help: "Total number of tombstone events emitted to the tap sink, by operation.",
+		}, []string{"op"}),
+		EventsDropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "prometheus_tsdb_tombstones_tap_events_dropped_total",
+			Help: "Total number of tombstone events dropped because the tap buffer was full.",
+		}),
+		QueueLength: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "prometheus_tsdb_tombstones_tap_queue_length",
+			Help: "Number of tombstone events currently buffered for delivery to the tap sink.",
+		}),
+	}
+	if reg != nil {
+		reg.MustRegister(m.EventsTotal, m.EventsDropped, m.QueueLength)
+	}
+	return m
+}
+
+// bufferedTap is the default Tap implementation: a bounded channel drained
+// by a single goroutine that frames and writes events to a Sink.
+type bufferedTap struct {
+	logger  *slog.Logger
+	metrics *TapMetrics
+	sink    Sink
+
+	queue chan TombstoneEvent
+	done  chan struct{}
+	wg    sync.WaitGroup
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// NewTap creates a Tap that streams events to sink from a background
+// goroutine. bufSize <= 0 uses tapDefaultBufferSize. A nil metrics is
+// replaced with an unregistered NewTapMetrics(nil), the same way a nil tap
+// passed to NewMemTombstonesWithTap falls back to a default.
+func NewTap(logger *slog.Logger, sink Sink, metrics *TapMetrics, bufSize int) Tap {
+	if bufSize <= 0 {
+		bufSize = tapDefaultBufferSize
+	}
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	if metrics == nil {
+		metrics = NewTapMetrics(nil)
+	}
+	t := &bufferedTap{
+		logger:  logger,
+		metrics: metrics,
+		sink:    sink,
+		queue:   make(chan TombstoneEvent, bufSize),
+		done:    make(chan struct{}),
+	}
+	t.wg.Add(1)
+	go t.run()
+	return t
+}
+
+func (t *bufferedTap) Emit(ev TombstoneEvent) {
+	if ev.Timestamp == 0 {
+		ev.Timestamp = time.Now().UnixNano()
+	}
+	select {
+	case t.queue <- ev:
+		t.metrics.QueueLength.Set(float64(len(t.queue)))
+	default:
+		t.metrics.EventsDropped.Inc()
+	}
+}
+
+func (t *bufferedTap) run() {
+	defer t.wg.Done()
+	for {
+		var ev TombstoneEvent
+		select {
+		case ev = <-t.queue:
+		case <-t.done:
+			// Flush whatever is still buffered before Close closes the sink.
+			select {
+			case ev = <-t.queue:
+			default:
+				return
+			}
+		}
+		t.metrics.QueueLength.Set(float64(len(t.queue)))
+		if err := writeFrame(t.sink, EncodeEvent(ev)); err != nil {
+			t.logger.Error("write tombstone tap event", "err", err.Error())
+			continue
+		}
+		t.metrics.EventsTotal.WithLabelValues(opLabel(ev.Op)).Inc()
+	}
+}
+
+// Close writes out any events still queued, stops the delivery goroutine
+// and closes the sink. It is safe to call more than once; only the first
+// call does any work.
+func (t *bufferedTap) Close() error {
+	t.closeOnce.Do(func() {
+		close(t.done)
+		t.wg.Wait()
+		t.closeErr = t.sink.Close()
+	})
+	return t.closeErr
+}
+
+func opLabel(op EventOp) string {
+	switch op {
+	case OpAddInterval:
+		return "add_interval"
+	case OpTruncateBefore:
+		return "truncate_before"
+	case OpDeleteTombstones:
+		return "delete_tombstones"
+	default:
+		return "unknown"
+	}
+}
+
+// NopTap discards every event. It is the zero value used by MemTombstones
+// when no Tap has been configured.
+var NopTap Tap = nopTap{}
+
+type nopTap struct{}
+
+func (nopTap) Emit(TombstoneEvent) {}
+func (nopTap) Close() error        { return nil }
+
+// FileSink opens (creating if necessary) a plain file as a Sink, appending
+// every frame written to it.
+func FileSink(path string) (Sink, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open tap file: %w", err)
+	}
+	return f, nil
+}
+
+// NetSink dials network, address (e.g. "unix", "/run/tombstones.sock" or
+// "tcp", "127.0.0.1:9999") and returns the connection as a Sink.
+func NetSink(network, address string) (Sink, error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, fmt.Errorf("dial tap sink: %w", err)
+	}
+	return conn, nil
+}
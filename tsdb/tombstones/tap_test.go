@@ -0,0 +1,139 @@
+// Copyright 2024 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tombstones
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prometheus/prometheus/storage"
+)
+
+func TestEncodeDecodeEventRoundtrip(t *testing.T) {
+	ev := TombstoneEvent{
+		SeriesRef: 42,
+		Mint:      10,
+		Maxt:      20,
+		Op:        OpAddInterval,
+		BlockULID: "01HQZ",
+		Timestamp: 1234,
+	}
+
+	got, err := DecodeEvent(EncodeEvent(ev))
+	require.NoError(t, err)
+	require.Equal(t, ev, got)
+}
+
+func TestDecodeEventRejectsUnknownFormat(t *testing.T) {
+	b := EncodeEvent(TombstoneEvent{SeriesRef: 1, Op: OpDeleteTombstones})
+	b[0] = eventFormatV1 + 1
+	_, err := DecodeEvent(b)
+	require.Error(t, err)
+}
+
+// blockingSink lets a test control exactly when bufferedTap.run's Write call
+// returns, so the tap's single-slot queue can be driven to the full/drop
+// state deterministically.
+type blockingSink struct {
+	release chan struct{}
+
+	mu    sync.Mutex
+	wrote [][]byte
+}
+
+func (s *blockingSink) Write(b []byte) (int, error) {
+	<-s.release
+	cp := append([]byte(nil), b...)
+	s.mu.Lock()
+	s.wrote = append(s.wrote, cp)
+	s.mu.Unlock()
+	return len(b), nil
+}
+
+func (s *blockingSink) Close() error { return nil }
+
+func TestBufferedTapDropsOnFullQueue(t *testing.T) {
+	sink := &blockingSink{release: make(chan struct{})}
+	metrics := NewTapMetrics(nil)
+	tap := NewTap(nil, sink, metrics, 1)
+
+	// The first event is picked up by the tap's goroutine immediately and
+	// blocks inside sink.Write, leaving the queue (capacity 1) empty and
+	// ready to accept exactly one more event before it starts dropping.
+	tap.Emit(TombstoneEvent{SeriesRef: 1, Op: OpAddInterval})
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(metrics.QueueLength) == 0
+	}, time.Second, time.Millisecond, "tap goroutine never dequeued the first event")
+
+	tap.Emit(TombstoneEvent{SeriesRef: 2, Op: OpAddInterval}) // fills the queue
+	tap.Emit(TombstoneEvent{SeriesRef: 3, Op: OpAddInterval}) // must be dropped
+
+	require.Equal(t, float64(1), testutil.ToFloat64(metrics.EventsDropped))
+
+	close(sink.release)
+	require.NoError(t, tap.Close())
+}
+
+func TestBufferedTapCloseIsIdempotent(t *testing.T) {
+	sink := &blockingSink{release: make(chan struct{})}
+	close(sink.release)
+	tap := NewTap(nil, sink, nil, 4)
+
+	require.NoError(t, tap.Close())
+	require.NotPanics(t, func() {
+		require.NoError(t, tap.Close())
+	})
+}
+
+func TestTruncateBeforeAndDeleteTombstonesOnlyRecordOnChange(t *testing.T) {
+	sink := &blockingSink{release: make(chan struct{})}
+	close(sink.release)
+	tap := NewTap(nil, sink, nil, 16)
+	m := NewMemTombstonesWithTap(tap)
+	m.AddInterval(1, Interval{Mint: 100, Maxt: 200})
+
+	// beforeT predates every stored interval, so nothing is removed and no
+	// event should be recorded.
+	m.TruncateBefore(50)
+	require.Equal(t, uint64(1), m.Revision(), "no-op TruncateBefore must not bump the revision")
+
+	// ref 2 was never added, so deleting it is a no-op.
+	m.DeleteTombstones(map[storage.SeriesRef]struct{}{2: {}})
+	require.Equal(t, uint64(1), m.Revision(), "no-op DeleteTombstones must not bump the revision")
+
+	// beforeT exceeds every stored interval's Maxt, so the whole series is
+	// dropped and the removal must be recorded.
+	m.TruncateBefore(250)
+	require.Equal(t, uint64(2), m.Revision(), "TruncateBefore that actually removes intervals must record")
+	ivs, err := m.Get(1)
+	require.NoError(t, err)
+	require.Empty(t, ivs, "series fully covered by beforeT must be deleted")
+
+	require.NoError(t, m.Close())
+}
+
+func TestNewTapDefaultsNilMetrics(t *testing.T) {
+	sink := &blockingSink{release: make(chan struct{})}
+	close(sink.release) // don't block Write; this test only checks no panic
+	tap := NewTap(nil, sink, nil, 4)
+
+	require.NotPanics(t, func() {
+		tap.Emit(TombstoneEvent{SeriesRef: storage.SeriesRef(1), Op: OpAddInterval})
+	})
+	require.NoError(t, tap.Close())
+}
@@ -0,0 +1,71 @@
+// Copyright 2017 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tombstones
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/prometheus/prometheus/storage"
+)
+
+// TestReadTombstonesLegacyFormat confirms WriteFile's default output (the v1,
+// single-CRC layout) is still what ReadTombstones expects, so rolling the
+// chunked container format out as an opt-in doesn't regress every existing
+// block directory on disk.
+func TestReadTombstonesLegacyFormat(t *testing.T) {
+	dir := t.TempDir()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	src := NewMemTombstones()
+	src.AddInterval(1, Interval{Mint: 1, Maxt: 10})
+	src.AddInterval(2, Interval{Mint: 5, Maxt: 15})
+
+	_, err := WriteFile(logger, dir, src)
+	require.NoError(t, err)
+
+	got, _, err := ReadTombstones(dir)
+	require.NoError(t, err)
+	require.Equal(t, src.Total(), got.Total())
+
+	ivs, err := got.Get(1)
+	require.NoError(t, err)
+	require.Equal(t, Intervals{{Mint: 1, Maxt: 10}}, ivs)
+}
+
+// TestReadTombstonesLegacyCodecExplicit is the same claim as
+// TestReadTombstonesLegacyFormat but goes through WriteFileWithCodec
+// directly with v1Codec{}, so it keeps holding even if WriteFile's default
+// codec ever changes.
+func TestReadTombstonesLegacyCodecExplicit(t *testing.T) {
+	dir := t.TempDir()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	src := NewMemTombstones()
+	for i := 1; i <= 100; i++ {
+		src.AddInterval(storage.SeriesRef(i), Interval{Mint: int64(i), Maxt: int64(i + 5)})
+	}
+
+	_, err := WriteFileWithCodec(logger, dir, src, v1Codec{})
+	require.NoError(t, err)
+
+	got, _, err := ReadTombstones(dir)
+	require.NoError(t, err)
+	require.Equal(t, src.Total(), got.Total())
+
+	ivs, err := got.Get(50)
+	require.NoError(t, err)
+	require.Equal(t, Intervals{{Mint: 50, Maxt: 55}}, ivs)
+}